@@ -2,27 +2,148 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
+	"math/rand"
 	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	requestTimeout = 30 * time.Second
+	maxRetries     = 5
+	baseRetryDelay = 200 * time.Millisecond
+	maxRetryDelay  = 10 * time.Second
 )
 
 type ApiClient struct {
-	BaseUrl string
+	BaseUrl    string
+	HttpClient *http.Client
+}
+
+func NewApiClient(baseUrl string) *ApiClient {
+	return &ApiClient{
+		BaseUrl: baseUrl,
+		HttpClient: &http.Client{
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: 10,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}
 }
 
-func (c *ApiClient) httpGetRaw(path string) ([]byte, int, error) {
-	request, err := http.NewRequest("GET", c.BaseUrl+path, nil)
+// retryDelay returns how long to wait before the next attempt, honoring a
+// Retry-After header if the server sent one and otherwise backing off
+// exponentially with jitter.
+func retryDelay(attempt int, retryAfter string) time.Duration {
+	if retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	backoff := time.Duration(float64(baseRetryDelay) * math.Pow(2, float64(attempt)))
+	if backoff > maxRetryDelay {
+		backoff = maxRetryDelay
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff)/2+1))
+}
+
+// do executes request, retrying network errors and 5xx responses with
+// exponential backoff + jitter. Set retry=false for non-idempotent requests
+// that shouldn't be replayed blindly. Set streaming=true for requests whose
+// body the caller reads after do() returns (e.g. a large download): such
+// requests run on ctx directly instead of a bounded per-attempt timeout, so
+// a slow body read isn't truncated and isn't capped by requestTimeout - only
+// the passed-in ctx (and ultimately SIGINT) can cancel them.
+func (c *ApiClient) do(ctx context.Context, newRequest func(ctx context.Context) (*http.Request, error), retry bool, streaming bool) (*http.Response, error) {
+	attempts := 1
+	if retry {
+		attempts = maxRetries
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		reqCtx := ctx
+		cancel := func() {}
+		if !streaming {
+			reqCtx, cancel = context.WithTimeout(ctx, requestTimeout)
+		}
+		request, err := newRequest(reqCtx)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		response, err := c.HttpClient.Do(request)
+		if err == nil && response.StatusCode < http.StatusInternalServerError {
+			cancel()
+			return response, nil
+		}
+
+		if err != nil {
+			lastErr = err
+		} else {
+			lastErr = fmt.Errorf("server returned %d", response.StatusCode)
+			response.Body.Close()
+		}
+
+		if ctx.Err() != nil {
+			cancel()
+			return nil, ctx.Err()
+		}
+
+		if attempt < attempts-1 {
+			delay := retryDelay(attempt, "")
+			if err == nil {
+				delay = retryDelay(attempt, response.Header.Get("Retry-After"))
+			}
+			select {
+			case <-ctx.Done():
+				cancel()
+				return nil, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+		cancel()
+	}
+
+	return nil, fmt.Errorf("giving up after %d attempt(s): %w", attempts, lastErr)
+}
+
+// httpGetStream issues a GET and returns the raw response for the caller to
+// stream-read; the caller owns response.Body and must close it. Useful for
+// large downloads (e.g. env archives) that shouldn't be buffered twice.
+func (c *ApiClient) httpGetStream(ctx context.Context, path string, headers map[string]string) (*http.Response, error) {
+	response, err := c.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		request, err := http.NewRequestWithContext(ctx, "GET", c.BaseUrl+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range headers {
+			request.Header.Set(key, value)
+		}
+		return request, nil
+	}, true, true)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
+	return response, nil
+}
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+func (c *ApiClient) httpGetRaw(ctx context.Context, path string) ([]byte, int, error) {
+	response, err := c.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "GET", c.BaseUrl+path, nil)
+	}, true, false)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to send request: %w", err)
 	}
+	defer response.Body.Close()
 
 	body, err := io.ReadAll(response.Body)
 	if err != nil {
@@ -32,8 +153,8 @@ func (c *ApiClient) httpGetRaw(path string) ([]byte, int, error) {
 	return body, response.StatusCode, nil
 }
 
-func (c *ApiClient) httpGet(path string) (map[string]interface{}, int, error) {
-	body, statusCode, err := c.httpGetRaw(path)
+func (c *ApiClient) httpGet(ctx context.Context, path string) (map[string]interface{}, int, error) {
+	body, statusCode, err := c.httpGetRaw(ctx, path)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to get raw response: %w", err)
 	}
@@ -51,22 +172,28 @@ func (c *ApiClient) httpGet(path string) (map[string]interface{}, int, error) {
 	return jsonResponse, statusCode, nil
 }
 
-func (c *ApiClient) httpPostRaw(path string, jsonData map[string]string) ([]byte, int, error) {
-	jsonBytes, err := json.Marshal(jsonData)
+// httpPostRaw posts body (anything json.Marshal accepts, including nil) to
+// path. Set retry=true only for requests the server can safely see more
+// than once (e.g. heartbeats, registration) - retries are attempted on
+// network errors and 5xx responses, honoring Retry-After.
+func (c *ApiClient) httpPostRaw(ctx context.Context, path string, body interface{}, retry bool) ([]byte, int, error) {
+	jsonBytes, err := json.Marshal(body)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to marshal json: %w", err)
 	}
 
-	request, err := http.NewRequest("POST", c.BaseUrl+path, bytes.NewBuffer(jsonBytes))
-	if err != nil {
-		return nil, 0, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	client := &http.Client{}
-	response, err := client.Do(request)
+	response, err := c.do(ctx, func(ctx context.Context) (*http.Request, error) {
+		request, err := http.NewRequestWithContext(ctx, "POST", c.BaseUrl+path, bytes.NewReader(jsonBytes))
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		return request, nil
+	}, retry, false)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to send request: %w", err)
 	}
+	defer response.Body.Close()
 
 	responseBody, err := io.ReadAll(response.Body)
 	if err != nil {
@@ -76,14 +203,14 @@ func (c *ApiClient) httpPostRaw(path string, jsonData map[string]string) ([]byte
 	return responseBody, response.StatusCode, nil
 }
 
-func (c *ApiClient) httpPost(path string, jsonData map[string]string) (map[string]interface{}, int, error) {
-	body, statusCode, err := c.httpPostRaw(path, jsonData)
+func (c *ApiClient) httpPost(ctx context.Context, path string, body interface{}, retry bool) (map[string]interface{}, int, error) {
+	responseBody, statusCode, err := c.httpPostRaw(ctx, path, body, retry)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to post raw response: %w", err)
 	}
 
 	var jsonResponse map[string]interface{}
-	err = json.Unmarshal(body, &jsonResponse)
+	err = json.Unmarshal(responseBody, &jsonResponse)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to unmarshal response body: %w", err)
 	}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// extractArchive dispatches to the right extractor for contentType. Zip
+// needs random access to seek the central directory, so it's buffered to
+// tempDir first; tar.gz is extracted straight off src with no disk buffer.
+func extractArchive(src io.Reader, contentType, tempDir, destPath string) error {
+	if strings.Contains(contentType, "gzip") {
+		return extractTarGz(src, destPath)
+	}
+
+	zipPath := filepath.Join(tempDir, "env.zip")
+	zipFile, err := os.Create(zipPath)
+	if err != nil {
+		return fmt.Errorf("failed to create zip file: %w", err)
+	}
+	_, err = io.Copy(zipFile, src)
+	zipFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to buffer zip file: %w", err)
+	}
+
+	return extractZip(zipPath, destPath)
+}
+
+// extractTarGz streams a gzip-compressed tar archive straight into destPath
+// without buffering it to disk first.
+func extractTarGz(src io.Reader, destPath string) error {
+	gzReader, err := gzip.NewReader(src)
+	if err != nil {
+		return fmt.Errorf("failed to open gzip stream: %w", err)
+	}
+	defer gzReader.Close()
+
+	tarReader := tar.NewReader(gzReader)
+	var totalSize int64
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar entry: %w", err)
+		}
+
+		if header.Typeflag == tar.TypeSymlink || header.Typeflag == tar.TypeLink {
+			return fmt.Errorf("refusing to extract link entry %q", header.Name)
+		}
+
+		filePath, err := safeJoin(destPath, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(filePath, 0755); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+		case tar.TypeReg:
+			if header.Size > maxFileSize {
+				return fmt.Errorf("entry %q exceeds max file size (%d bytes)", header.Name, maxFileSize)
+			}
+			totalSize += header.Size
+			if totalSize > maxTotalSize {
+				return fmt.Errorf("archive exceeds max total size (%d bytes)", maxTotalSize)
+			}
+
+			if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+
+			destFile, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return fmt.Errorf("failed to create destination file: %w", err)
+			}
+
+			_, err = io.CopyN(destFile, tarReader, header.Size)
+			destFile.Close()
+			if err != nil && err != io.EOF {
+				return fmt.Errorf("failed to copy file contents: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
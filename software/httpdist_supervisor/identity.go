@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const supervisorVersion = "0.1.0"
+
+// workerIdFile returns where this worker's persistent identity lives,
+// honoring XDG_STATE_HOME with the usual ~/.local/state fallback.
+func workerIdFile() (string, error) {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to determine home directory: %w", err)
+		}
+		stateHome = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(stateHome, programName, "worker_id"), nil
+}
+
+// getWorkerId returns this worker's persistent UUID, generating and
+// stashing one on first run. Unlike deriving it from a MAC address, this
+// survives container restarts, USB NIC swaps, and renamed interfaces.
+func getWorkerId() (string, error) {
+	path, err := workerIdFile()
+	if err != nil {
+		return "", err
+	}
+
+	if existing, err := os.ReadFile(path); err == nil {
+		return strings.TrimSpace(string(existing)), nil
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate worker id: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create state directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(id+"\n"), 0644); err != nil {
+		return "", fmt.Errorf("failed to persist worker id: %w", err)
+	}
+
+	return id, nil
+}
+
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// macAddress reports the MAC of the first matching interface, purely so it
+// can be carried along as a label for operators who keyed on it before.
+func macAddress() (string, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return "", fmt.Errorf("failed to get interfaces: %w", err)
+	}
+
+	for _, iface := range interfaces {
+		for _, ifaceName := range ifaceNames {
+			if iface.Name == ifaceName {
+				return strings.ReplaceAll(iface.HardwareAddr.String(), ":", ""), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no matching interface found from: %v", ifaceNames)
+}
+
+// resourceLimitPrefix marks labels file entries that cap the concurrency of
+// a shared physical resource (e.g. "resource.jtag=1") rather than describe
+// a capability of the worker (e.g. "jtag=jlink"). Keeping the two in
+// separate namespaces means an operator can report which JTAG probe a
+// worker has *and* limit how many sessions may use it at once, without one
+// clobbering the other.
+const resourceLimitPrefix = "resource."
+
+// readConfigFile reads a simple "key=value" per line config file. A missing
+// file just means no entries; that's not an error.
+func readConfigFile(path string) (map[string]string, error) {
+	entries := map[string]string{}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open labels file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed label line: %q", line)
+		}
+		entries[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read labels file: %w", err)
+	}
+
+	return entries, nil
+}
+
+// loadLabels reads operator-supplied capability labels from a simple
+// "key=value" per line config file, e.g.:
+//
+//	board=stm32f4-disco
+//	psu=rigol-dp832
+//	jtag=jlink
+//
+// Entries under the "resource." prefix are resource capacity limits, not
+// capability labels - see loadResourceLimits - and are excluded here so
+// they don't get reported upstream as labels.
+//
+// A missing file just means no extra labels; that's not an error.
+func loadLabels(path string) (map[string]string, error) {
+	entries, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := map[string]string{}
+	for key, value := range entries {
+		if strings.HasPrefix(key, resourceLimitPrefix) {
+			continue
+		}
+		labels[key] = value
+	}
+
+	return labels, nil
+}
+
+// loadResourceLimits reads "resource.<name>=<capacity>" entries from the
+// same labels file, e.g. "resource.jtag=1" caps concurrent sessions
+// claiming the "jtag" resource to 1, so a worker with a single probe never
+// runs two sessions that both need it.
+func loadResourceLimits(path string) (map[string]int, error) {
+	entries, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := map[string]int{}
+	for key, value := range entries {
+		if !strings.HasPrefix(key, resourceLimitPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, resourceLimitPrefix)
+		capacity, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("malformed resource limit %q: %w", key, err)
+		}
+		if capacity > 0 {
+			limits[name] = capacity
+		}
+	}
+
+	return limits, nil
+}
+
+// detectUSBDevices best-effort lists "vid:pid" pairs for attached USB
+// devices by walking sysfs. Any failure just yields an empty list - this is
+// a nice-to-have for routing, not something worth failing registration over.
+func detectUSBDevices() []string {
+	entries, err := os.ReadDir("/sys/bus/usb/devices")
+	if err != nil {
+		return nil
+	}
+
+	var devices []string
+	for _, entry := range entries {
+		base := filepath.Join("/sys/bus/usb/devices", entry.Name())
+		vendor, err := os.ReadFile(filepath.Join(base, "idVendor"))
+		if err != nil {
+			continue
+		}
+		product, err := os.ReadFile(filepath.Join(base, "idProduct"))
+		if err != nil {
+			continue
+		}
+		devices = append(devices, fmt.Sprintf("%s:%s", strings.TrimSpace(string(vendor)), strings.TrimSpace(string(product))))
+	}
+
+	return devices
+}
+
+// workerCapabilities describes this worker for the coordinator's routing:
+// what hardware it can drive, so sessions with pytest markers like
+// `@pytest.mark.requires(board="stm32f4-disco")` land somewhere that has one.
+type workerCapabilities struct {
+	Hostname   string            `json:"hostname"`
+	OS         string            `json:"os"`
+	Arch       string            `json:"arch"`
+	Labels     map[string]string `json:"labels"`
+	USBDevices []string          `json:"usb_devices"`
+	Version    string            `json:"version"`
+}
+
+func buildCapabilities(labelsPath string) (workerCapabilities, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return workerCapabilities{}, fmt.Errorf("failed to get hostname: %w", err)
+	}
+
+	labels, err := loadLabels(labelsPath)
+	if err != nil {
+		return workerCapabilities{}, err
+	}
+	if mac, err := macAddress(); err == nil {
+		labels["mac"] = mac
+	}
+
+	return workerCapabilities{
+		Hostname:   hostname,
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		Labels:     labels,
+		USBDevices: detectUSBDevices(),
+		Version:    supervisorVersion,
+	}, nil
+}
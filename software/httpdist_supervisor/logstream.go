@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	logFlushInterval = 250 * time.Millisecond
+	logFlushBytes    = 16 * 1024
+	ringBufferCap    = 1 << 20 // 1 MiB of buffered log/event bytes before we start dropping the oldest
+)
+
+// ringWriter is a bounded byte buffer with drop-oldest semantics: once full,
+// writes evict the oldest bytes rather than blocking the caller. This gives
+// us backpressure against a stalled or 429'd upload without ever blocking
+// the pytest child's stdout/stderr.
+type ringWriter struct {
+	mu       sync.Mutex
+	buf      []byte
+	cap      int
+	dropped  bool
+	notEmpty chan struct{}
+}
+
+func newRingWriter(capacity int) *ringWriter {
+	return &ringWriter{
+		cap:      capacity,
+		notEmpty: make(chan struct{}, 1),
+	}
+}
+
+func (r *ringWriter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	r.buf = append(r.buf, p...)
+	if overflow := len(r.buf) - r.cap; overflow > 0 {
+		r.buf = r.buf[overflow:]
+		if !r.dropped {
+			r.dropped = true
+			log.Printf("warning: log buffer full, dropping oldest output")
+		}
+	}
+	r.mu.Unlock()
+
+	select {
+	case r.notEmpty <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+// drain returns and clears whatever is currently buffered.
+func (r *ringWriter) drain() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.buf) == 0 {
+		return nil
+	}
+	out := r.buf
+	r.buf = nil
+	r.dropped = false
+	return out
+}
+
+// pump flushes the ring buffer into w every flushInterval, or as soon as
+// flushBytes have accumulated, until ctx is done.
+func (r *ringWriter) pump(ctx context.Context, w io.Writer, flushInterval time.Duration, flushBytes int) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	flush := func() {
+		if chunk := r.drain(); len(chunk) > 0 {
+			w.Write(chunk)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			flush()
+			return
+		case <-ticker.C:
+			flush()
+		case <-r.notEmpty:
+			r.mu.Lock()
+			big := len(r.buf) >= flushBytes
+			r.mu.Unlock()
+			if big {
+				flush()
+			}
+		}
+	}
+}
+
+// logStreamer fans a test session's stdout/stderr into a single chunked
+// POST request body, behaving like `docker logs -f` for the coordinator.
+type logStreamer struct {
+	writer io.Writer // write here to enqueue output for streaming
+	stop   context.CancelFunc
+	done   chan struct{}
+}
+
+// startLogStreamer opens a long-lived POST whose body is a never-closing
+// io.Pipe; bytes written to the returned streamer are flushed to that pipe
+// in the background and show up as they arrive on the server side. Call
+// close() once the test process has exited to flush the remainder and end
+// the upload.
+//
+// The upload runs on its own context rather than the session's, which may
+// already be canceled (timeout/shutdown abort) while the pytest child is
+// still winding down during its SIGINT grace period - tying the two
+// together would drop exactly the tail-end output an operator needs to
+// diagnose the abort. Only close() ends it.
+func startLogStreamer(apiClient *ApiClient, session *TestSession) *logStreamer {
+	streamCtx, cancel := context.WithCancel(context.Background())
+	pipeReader, pipeWriter := io.Pipe()
+	buffer := newRingWriter(ringBufferCap)
+	streamer := &logStreamer{writer: buffer, stop: cancel, done: make(chan struct{})}
+
+	path := fmt.Sprintf("/worker/%s/session/%s/logs", session.WorkerId, session.SessionId)
+
+	go func() {
+		defer close(streamer.done)
+
+		uploadDone := make(chan struct{})
+		go func() {
+			defer close(uploadDone)
+
+			request, err := http.NewRequestWithContext(streamCtx, "POST", apiClient.BaseUrl+path, pipeReader)
+			if err != nil {
+				log.Printf("failed to build log stream request: %v", err)
+				return
+			}
+			request.Header.Set("Content-Type", "application/octet-stream")
+			request.Header.Set("Transfer-Encoding", "chunked")
+
+			response, err := apiClient.HttpClient.Do(request)
+			if err != nil {
+				if streamCtx.Err() == nil {
+					log.Printf("log stream upload failed: %v", err)
+				}
+				return
+			}
+			defer response.Body.Close()
+			io.Copy(io.Discard, response.Body)
+		}()
+
+		// pump blocks, flushing on a timer/size threshold, until close()
+		// cancels streamCtx; the final flush happens before we return.
+		buffer.pump(streamCtx, pipeWriter, logFlushInterval, logFlushBytes)
+		pipeWriter.Close()
+		<-uploadDone
+	}()
+
+	return streamer
+}
+
+func (s *logStreamer) Write(p []byte) (int, error) {
+	return s.writer.Write(p)
+}
+
+// close flushes any remaining buffered output and blocks until the upload
+// request has finished.
+func (s *logStreamer) close() {
+	s.stop()
+	<-s.done
+}
+
+// eventSocketPath is where the --httpdist-events-socket pytest plugin hook
+// writes newline-delimited JSON test events for this session.
+func eventSocketPath(session *TestSession) string {
+	return fmt.Sprintf("%s/events.sock", session.EnvDir)
+}
+
+// startEventForwarder listens on a Unix socket inside the env dir that the
+// pytest plugin connects to and writes structured JSON events
+// (test started/passed/failed/xfail, with durations) into, one per line.
+// Each line is forwarded upstream as part of a single NDJSON chunked POST,
+// with the same drop-oldest backpressure as the raw log stream.
+func startEventForwarder(ctx context.Context, apiClient *ApiClient, session *TestSession) (cleanup func(), err error) {
+	socketPath := eventSocketPath(session)
+	os.Remove(socketPath) // stale socket from a previous run, if any
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on event socket: %w", err)
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	pipeReader, pipeWriter := io.Pipe()
+	buffer := newRingWriter(ringBufferCap)
+	path := fmt.Sprintf("/worker/%s/session/%s/events", session.WorkerId, session.SessionId)
+
+	uploadDone := make(chan struct{})
+	go func() {
+		defer close(uploadDone)
+
+		request, err := http.NewRequestWithContext(ctx, "POST", apiClient.BaseUrl+path, pipeReader)
+		if err != nil {
+			log.Printf("failed to build event stream request: %v", err)
+			return
+		}
+		request.Header.Set("Content-Type", "application/x-ndjson")
+
+		response, err := apiClient.HttpClient.Do(request)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("event stream upload failed: %v", err)
+			}
+			return
+		}
+		defer response.Body.Close()
+		io.Copy(io.Discard, response.Body)
+	}()
+
+	go func() {
+		buffer.pump(streamCtx, pipeWriter, logFlushInterval, logFlushBytes)
+		pipeWriter.Close()
+	}()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go forwardEventLines(conn, buffer)
+		}
+	}()
+
+	return func() {
+		listener.Close()
+		os.Remove(socketPath)
+		cancel()
+		<-uploadDone
+	}, nil
+}
+
+func forwardEventLines(conn net.Conn, buffer *ringWriter) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		buffer.Write(append(scanner.Bytes(), '\n'))
+	}
+}
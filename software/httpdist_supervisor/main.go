@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
 	"time"
 )
 
@@ -15,103 +20,121 @@ const (
 
 var apiUrl = getEnvOrDefault("HTTPDIST_API_URL", defaultApiUrl)
 var ifaceNames = []string{"eth0", "en0", "wlan0"}
+var labelsPath = getEnvOrDefault("HTTPDIST_LABELS_FILE", "/etc/httpdist-supervisor/labels.conf")
 
-func getWorkerId() (string, error) {
-	// get mac address
-	interfaces, err := net.Interfaces()
-	if err != nil {
-		return "", fmt.Errorf("failed to get interfaces: %w", err)
-	}
-
-	for _, iface := range interfaces {
-		for _, ifaceName := range ifaceNames {
-			if iface.Name == ifaceName {
-				macAddr := iface.HardwareAddr.String()
-				macAddrNoColons := ""
-				for _, c := range macAddr {
-					if c != ':' {
-						macAddrNoColons += string(c)
-					}
-				}
-				return macAddrNoColons, nil
-			}
-		}
-	}
-
-	return "", fmt.Errorf("no matching interface found from: %v", ifaceNames)
+type registerRequest struct {
+	WorkerId string `json:"worker_id"`
+	workerCapabilities
 }
 
-func (c *ApiClient) registerWorker(workerId string) {
-	jsonData := map[string]string{
-		"worker_id": workerId,
+func (c *ApiClient) registerWorker(ctx context.Context, workerId string) error {
+	capabilities, err := buildCapabilities(labelsPath)
+	if err != nil {
+		return err
 	}
 
-	responseJson, statusCode, err := c.httpPost("/worker/register", jsonData)
+	responseJson, statusCode, err := c.httpPost(ctx, "/worker/register", registerRequest{
+		WorkerId:           workerId,
+		workerCapabilities: capabilities,
+	}, true)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	if statusCode != http.StatusOK {
-		log.Fatalf("failed to register worker: %s", responseJson["detail"])
+		return fmt.Errorf("failed to register worker: %s", responseJson["detail"])
 	}
 
 	fmt.Printf("Registered worker: %s\n", responseJson["worker_id"])
+	return nil
 }
 
-func sendHeartbeat(c *ApiClient, workerId string) {
+func sendHeartbeat(ctx context.Context, c *ApiClient, workerId string) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
 	for {
-		c.httpPost(fmt.Sprintf("/worker/%s/heartbeat", workerId), nil)
-		time.Sleep(10 * time.Second)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, statusCode, err := c.httpPost(ctx, fmt.Sprintf("/worker/%s/heartbeat", workerId), nil, true)
+			if err == nil && statusCode == http.StatusNotFound {
+				// The coordinator restarted and forgot about us - re-register so
+				// it can schedule sessions onto this worker again. A failure here
+				// just means we try again on the next heartbeat tick - it must not
+				// take down every other concurrently running session.
+				if err := c.registerWorker(ctx, workerId); err != nil {
+					log.Printf("failed to re-register worker: %v", err)
+				}
+			}
+		}
 	}
 }
 
-func pollForSession(c *ApiClient, workerId string) (*TestSession, error) {
-	spinnerIdx := 0
-
+// pollForSession long-polls for a session assigned to this worker. slots
+// tells the coordinator how many sessions this worker can run at once, so
+// it can hand out (or queue) work accordingly.
+func pollForSession(ctx context.Context, c *ApiClient, workerId string, slots int, board *statusBoard, slot int) (*TestSession, error) {
 	for {
-		spinnerIdx = updateSpinner("Waiting for session", spinnerIdx)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		board.set(slot, "waiting for session")
 
-		responseJson, statusCode, err := c.httpGet(fmt.Sprintf("/worker/%s/session", workerId))
+		responseJson, statusCode, err := c.httpGet(ctx, fmt.Sprintf("/worker/%s/session?slots=%d", workerId, slots))
 		if err != nil {
-			log.Fatal(err)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("failed to poll for session: %w", err)
 		}
 
 		if statusCode == http.StatusNoContent {
-			time.Sleep(1 * time.Second)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(1 * time.Second):
+			}
 			continue
 		} else if statusCode != http.StatusOK {
-			log.Fatalf("failed to get session: %d (%s)", statusCode, responseJson["detail"])
+			return nil, fmt.Errorf("failed to get session: %d (%s)", statusCode, responseJson["detail"])
 		}
 
 		sessionId := responseJson["session_id"].(string)
 
-		clearSpinner()
-		fmt.Printf("Received session: %s\n", sessionId)
-		return &TestSession{WorkerId: workerId, SessionId: sessionId}, nil
-	}
-}
-
-func runSession(apiClient *ApiClient, workerId string) {
-	session, err := pollForSession(apiClient, workerId)
-	if err != nil {
-		log.Fatal(err)
-	}
+		var resources []string
+		if raw, ok := responseJson["resources"].([]interface{}); ok {
+			for _, r := range raw {
+				if name, ok := r.(string); ok {
+					resources = append(resources, name)
+				}
+			}
+		}
 
-	err = session.prepareEnv(apiClient)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer session.cleanup()
+		var timeoutSeconds float64
+		if deadline, ok := responseJson["timeout_seconds"].(float64); ok {
+			timeoutSeconds = deadline
+		}
 
-	err = session.spawnWorker()
-	if err != nil {
-		log.Fatal(err)
+		board.set(slot, "received session %s", sessionId)
+		return &TestSession{
+			WorkerId:       workerId,
+			SessionId:      sessionId,
+			Resources:      resources,
+			TimeoutSeconds: timeoutSeconds,
+		}, nil
 	}
 }
 
 func main() {
-	apiClient := &ApiClient{
-		BaseUrl: apiUrl,
-	}
+	maxParallel := flag.Int("max-parallel", runtime.NumCPU(), "maximum number of test sessions to run concurrently")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	apiClient := NewApiClient(apiUrl)
 
 	workerId, err := getWorkerId()
 	if err != nil {
@@ -119,11 +142,20 @@ func main() {
 	}
 	fmt.Println("Starting worker with ID:", workerId)
 
-	go sendHeartbeat(apiClient, workerId)
+	if err := apiClient.registerWorker(ctx, workerId); err != nil {
+		log.Fatal(err)
+	}
 
-	// apiClient.registerWorker(workerId)
+	go sendHeartbeat(ctx, apiClient, workerId)
 
-	for {
-		runSession(apiClient, workerId)
+	sessionRunner, err := NewSessionRunner(apiClient, workerId, *maxParallel)
+	if err != nil {
+		log.Fatal(err)
 	}
+	sessionRunner.Run(ctx)
+
+	// All slots have wound down (or force-killed their pytest child) - tell
+	// the coordinator we're gone so it can reschedule immediately instead of
+	// waiting for our heartbeat to time out.
+	apiClient.deregisterWorker(workerId)
 }
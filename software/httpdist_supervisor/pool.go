@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SessionRunner pulls sessions concurrently across a bounded pool of slots,
+// each with its own temp dir, TestSession, and in-flight log/event upload.
+type SessionRunner struct {
+	apiClient   *ApiClient
+	workerId    string
+	maxParallel int
+	resources   map[string]chan struct{}
+	board       *statusBoard
+}
+
+// NewSessionRunner sizes the pool at maxParallel and derives labeled
+// resource semaphores from the worker's labels file: an entry like
+// "resource.jtag=1" caps concurrent sessions claiming the "jtag" resource
+// to 1, so a worker with a single probe never runs two sessions that both
+// need it, even though other slots stay busy. A malformed entry is a
+// config error worth failing startup over, not silently running with no
+// shared-hardware protection.
+func NewSessionRunner(apiClient *ApiClient, workerId string, maxParallel int) (*SessionRunner, error) {
+	limits, err := loadResourceLimits(labelsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load resource limits: %w", err)
+	}
+
+	resources := map[string]chan struct{}{}
+	for name, capacity := range limits {
+		resources[name] = make(chan struct{}, capacity)
+	}
+
+	return &SessionRunner{
+		apiClient:   apiClient,
+		workerId:    workerId,
+		maxParallel: maxParallel,
+		resources:   resources,
+		board:       newStatusBoard(maxParallel),
+	}, nil
+}
+
+func (r *SessionRunner) acquireResources(ctx context.Context, names []string) error {
+	for i, name := range names {
+		sem, ok := r.resources[name]
+		if !ok {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			r.releaseResources(names[:i])
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (r *SessionRunner) releaseResources(names []string) {
+	for _, name := range names {
+		if sem, ok := r.resources[name]; ok {
+			<-sem
+		}
+	}
+}
+
+// Run starts maxParallel slots, each repeatedly polling for and executing
+// sessions until ctx is canceled.
+func (r *SessionRunner) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for slot := 0; slot < r.maxParallel; slot++ {
+		wg.Add(1)
+		go func(slot int) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				r.runOne(ctx, slot)
+			}
+		}(slot)
+	}
+	wg.Wait()
+}
+
+func (r *SessionRunner) runOne(ctx context.Context, slot int) {
+	session, err := pollForSession(ctx, r.apiClient, r.workerId, r.maxParallel, r.board, slot)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.board.set(slot, "failed to poll for session: %v", err)
+		}
+		return
+	}
+
+	abort := newSessionAbortController(ctx)
+	defer abort.stop()
+	disarmDeadline := abort.armDeadline(time.Duration(session.TimeoutSeconds * float64(time.Second)))
+	defer disarmDeadline()
+	defer func() {
+		if reason := abort.Reason(); reason != "" {
+			r.apiClient.notifyAbort(r.workerId, session.SessionId, reason)
+		}
+	}()
+
+	r.board.set(slot, "%s: preparing environment", session.SessionId)
+	err = session.prepareEnv(abort.ctx, r.apiClient)
+	defer session.cleanup()
+	if err != nil {
+		r.board.set(slot, "%s: failed to prepare environment: %v", session.SessionId, err)
+		return
+	}
+
+	if err := r.acquireResources(abort.ctx, session.Resources); err != nil {
+		r.board.set(slot, "%s: aborted waiting for %v: %v", session.SessionId, session.Resources, err)
+		return
+	}
+	defer r.releaseResources(session.Resources)
+
+	r.board.set(slot, "%s: running", session.SessionId)
+	if err := session.spawnWorker(abort.ctx, r.apiClient); err != nil {
+		r.board.set(slot, "%s: failed: %v", session.SessionId, err)
+		return
+	}
+	r.board.set(slot, "%s: done", session.SessionId)
+}
@@ -1,45 +1,58 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 )
 
+// shutdownGracePeriod is how long a pytest child gets to exit after
+// receiving SIGINT before spawnWorker escalates to SIGKILL.
+const shutdownGracePeriod = 30 * time.Second
+
 type TestSession struct {
 	WorkerId  string
 	SessionId string
-	EnvDir    string
+	// TempDir is the session's scratch directory (holding EnvDir and any
+	// archive staging files); set as soon as prepareEnv creates it so
+	// cleanup can remove it even if prepareEnv fails partway through.
+	TempDir string
+	EnvDir  string
+	// Resources are labeled capacity slots (e.g. "jtag") this session needs
+	// exclusive access to for its duration; see SessionRunner.
+	Resources []string
+	// TimeoutSeconds is the session's wall-clock deadline as handed out by
+	// pollForSession; zero means no deadline is enforced client-side.
+	TimeoutSeconds float64
 }
 
-func (session *TestSession) prepareEnv(apiClient *ApiClient) error {
+func (session *TestSession) prepareEnv(ctx context.Context, apiClient *ApiClient) error {
 	tempDir, err := os.MkdirTemp("", programName)
 	if err != nil {
 		return fmt.Errorf("failed to createTempDir(): %w", err)
 	}
+	session.TempDir = tempDir
 
-	zipPath := filepath.Join(tempDir, "env.zip")
-	zipFile, err := os.Create(zipPath)
-	if err != nil {
-		return fmt.Errorf("failed to create zip file: %w", err)
-	}
-	defer zipFile.Close()
-
-	zipContent, statusCode, err := apiClient.httpGetRaw(fmt.Sprintf("/worker/%s/session/%s/env", session.WorkerId, session.SessionId))
+	response, err := apiClient.httpGetStream(
+		ctx,
+		fmt.Sprintf("/worker/%s/session/%s/env", session.WorkerId, session.SessionId),
+		map[string]string{"Accept": "application/zip, application/gzip"},
+	)
 	if err != nil {
-		return fmt.Errorf("failed to download env.zip: %w", err)
-	}
-	if statusCode != 200 {
-		return fmt.Errorf("failed to download env.zip: status code %d", statusCode)
+		return fmt.Errorf("failed to download env archive: %w", err)
 	}
-
-	_, err = zipFile.Write(zipContent)
-	if err != nil {
-		return fmt.Errorf("failed to write zip file: %w", err)
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		return fmt.Errorf("failed to download env archive: status code %d", response.StatusCode)
 	}
-	zipFile.Close()
 
 	envDir := filepath.Join(tempDir, "env")
 	err = os.MkdirAll(envDir, 0755)
@@ -47,18 +60,34 @@ func (session *TestSession) prepareEnv(apiClient *ApiClient) error {
 		return fmt.Errorf("failed to create env directory: %w", err)
 	}
 
-	err = extractZip(zipPath, envDir)
+	hasher := sha256.New()
+	err = extractArchive(io.TeeReader(response.Body, hasher), response.Header.Get("Content-Type"), tempDir, envDir)
 	if err != nil {
-		return fmt.Errorf("failed to extract zip file: %w", err)
+		return fmt.Errorf("failed to extract env archive: %w", err)
+	}
+	io.Copy(hasher, response.Body) // drain any trailer left unread by the extractor
+
+	if expectedDigest := response.Header.Get("X-Env-Sha256"); expectedDigest != "" {
+		actualDigest := hex.EncodeToString(hasher.Sum(nil))
+		if !strings.EqualFold(actualDigest, expectedDigest) {
+			return fmt.Errorf("env archive checksum mismatch: expected %s, got %s", expectedDigest, actualDigest)
+		}
 	}
 
 	session.EnvDir = envDir
 	return nil
 }
 
-func (session *TestSession) spawnWorker() error {
+func (session *TestSession) spawnWorker(ctx context.Context, apiClient *ApiClient) error {
+	eventsCleanup, err := startEventForwarder(ctx, apiClient, session)
+	if err != nil {
+		return fmt.Errorf("failed to start event forwarder: %w", err)
+	}
+	defer eventsCleanup()
+
 	// TOOD: pytest args
-	cmd := exec.Command(
+	cmd := exec.CommandContext(
+		ctx,
 		"uv",
 		"run",
 		"--isolated",
@@ -67,12 +96,24 @@ func (session *TestSession) spawnWorker() error {
 		session.WorkerId,
 		"--httpdist-session-id",
 		session.SessionId,
+		"--httpdist-events-socket",
+		eventSocketPath(session),
 	)
 
 	cmd.Dir = session.EnvDir
 
+	// On cancellation, ask pytest to wind down before killing it outright -
+	// a hung child otherwise pins this slot forever.
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGINT) }
+	cmd.WaitDelay = shutdownGracePeriod
+
+	logs := startLogStreamer(apiClient, session)
+	cmd.Stdout = logs
+	cmd.Stderr = logs
+
 	start := time.Now()
 	cmd.Run()
+	logs.close()
 
 	elapsed := time.Since(start)
 	fmt.Printf("Executed test session %s in %.2fs\n", session.SessionId, elapsed.Seconds())
@@ -81,6 +122,8 @@ func (session *TestSession) spawnWorker() error {
 }
 
 func (session *TestSession) cleanup() error {
-	os.RemoveAll(session.EnvDir)
+	if session.TempDir != "" {
+		os.RemoveAll(session.TempDir)
+	}
 	return nil
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// abortReason is sent to the coordinator so it knows why a session died
+// instead of just seeing it go silent until the heartbeat times out.
+type abortReason string
+
+const (
+	abortReasonShutdown abortReason = "shutdown"
+	abortReasonTimeout  abortReason = "timeout"
+	abortReasonOOM      abortReason = "oom"
+)
+
+const abortNotifyTimeout = 5 * time.Second
+
+// sessionAbortController cancels a session's context on the first abort
+// trigger (operator shutdown via the parent context, or the wall-clock
+// deadline from armDeadline) while remembering which one fired so it can be
+// reported upstream. Nothing here detects OOM; abortReasonOOM is recorded
+// only if a caller passes it to abort().
+type sessionAbortController struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.Mutex
+	reason abortReason
+}
+
+func newSessionAbortController(parent context.Context) *sessionAbortController {
+	ctx, cancel := context.WithCancel(parent)
+	controller := &sessionAbortController{ctx: ctx, cancel: cancel, done: make(chan struct{})}
+
+	go func() {
+		select {
+		case <-parent.Done():
+			controller.abort(abortReasonShutdown)
+		case <-controller.done:
+		}
+	}()
+
+	return controller
+}
+
+// stop releases the goroutine watching for operator shutdown once the
+// session has finished normally; without it, that goroutine would only
+// ever exit when the whole process shuts down, leaking one per session
+// for the life of a long-running worker.
+func (a *sessionAbortController) stop() {
+	a.mu.Lock()
+	select {
+	case <-a.done:
+	default:
+		close(a.done)
+	}
+	a.mu.Unlock()
+	a.cancel()
+}
+
+func (a *sessionAbortController) abort(reason abortReason) {
+	a.mu.Lock()
+	if a.reason == "" {
+		a.reason = reason
+	}
+	a.mu.Unlock()
+	a.cancel()
+}
+
+func (a *sessionAbortController) Reason() abortReason {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.reason
+}
+
+// armDeadline schedules an abort(timeout) after d, returning a function to
+// disarm it once the session finishes normally.
+func (a *sessionAbortController) armDeadline(d time.Duration) (disarm func()) {
+	if d <= 0 {
+		return func() {}
+	}
+	timer := time.AfterFunc(d, func() { a.abort(abortReasonTimeout) })
+	return func() { timer.Stop() }
+}
+
+// notifyAbort posts the abort reason to the coordinator. It deliberately
+// uses a fresh background context with its own short timeout, since the
+// session's own context is already canceled by the time this is called.
+func (c *ApiClient) notifyAbort(workerId, sessionId string, reason abortReason) {
+	ctx, cancel := context.WithTimeout(context.Background(), abortNotifyTimeout)
+	defer cancel()
+
+	path := fmt.Sprintf("/worker/%s/session/%s/abort", workerId, sessionId)
+	c.httpPost(ctx, path, map[string]string{"reason": string(reason)}, true)
+}
+
+// deregisterWorker tells the coordinator this worker is going away so it
+// can reschedule its in-flight work elsewhere immediately, rather than
+// waiting for the heartbeat to time out.
+func (c *ApiClient) deregisterWorker(workerId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), abortNotifyTimeout)
+	defer cancel()
+
+	c.httpPost(ctx, fmt.Sprintf("/worker/%s/deregister", workerId), nil, true)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// statusBoard renders one line per pool slot, redrawing in place with
+// carriage returns so concurrent sessions don't clobber each other's
+// output the way a single spinner would.
+type statusBoard struct {
+	mu       sync.Mutex
+	lines    []string
+	rendered bool
+}
+
+func newStatusBoard(slots int) *statusBoard {
+	return &statusBoard{lines: make([]string, slots)}
+}
+
+func (b *statusBoard) set(slot int, format string, args ...interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lines[slot] = fmt.Sprintf(format, args...)
+
+	if b.rendered {
+		fmt.Printf("\033[%dA", len(b.lines))
+	}
+	for i, line := range b.lines {
+		fmt.Printf("\r\033[K[slot %d] %s\n", i, line)
+	}
+	b.rendered = true
+}
@@ -6,17 +6,13 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
-func updateSpinner(message string, spinnerIdx int) int {
-	spinnerChars := []string{"|", "/", "-", "\\"}
-	fmt.Printf("\r%s %s", message, spinnerChars[spinnerIdx])
-	return (spinnerIdx + 1) % len(spinnerChars)
-}
-
-func clearSpinner() {
-	fmt.Print("\r                                \r")
-}
+const (
+	maxFileSize  = 512 << 20  // 512 MiB per extracted file
+	maxTotalSize = 2048 << 20 // 2 GiB total per extracted archive
+)
 
 func getEnvOrDefault(key, defaultValue string) string {
 	value := os.Getenv(key)
@@ -26,6 +22,19 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return value
 }
 
+// safeJoin resolves name against destPath and guarantees the result stays
+// inside destPath, rejecting zip-slip style "../" entries.
+func safeJoin(destPath, name string) (string, error) {
+	filePath := filepath.Join(destPath, name)
+
+	rel, err := filepath.Rel(destPath, filePath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("entry %q escapes destination directory", name)
+	}
+
+	return filePath, nil
+}
+
 func extractZip(zipPath, destPath string) error {
 	reader, err := zip.OpenReader(zipPath)
 	if err != nil {
@@ -33,13 +42,33 @@ func extractZip(zipPath, destPath string) error {
 	}
 	defer reader.Close()
 
+	var totalSize uint64
+
 	for _, file := range reader.File {
-		filePath := filepath.Join(destPath, file.Name)
+		if file.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to extract symlink entry %q", file.Name)
+		}
+
+		filePath, err := safeJoin(destPath, file.Name)
+		if err != nil {
+			return err
+		}
 
 		if file.FileInfo().IsDir() {
-			os.MkdirAll(filePath, 0755)
+			if err := os.MkdirAll(filePath, 0755); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
 			continue
 		}
+
+		if file.UncompressedSize64 > maxFileSize {
+			return fmt.Errorf("entry %q exceeds max file size (%d bytes)", file.Name, maxFileSize)
+		}
+		totalSize += file.UncompressedSize64
+		if totalSize > maxTotalSize {
+			return fmt.Errorf("archive exceeds max total size (%d bytes)", maxTotalSize)
+		}
+
 		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
 			return fmt.Errorf("failed to create directory: %w", err)
 		}
@@ -49,16 +78,16 @@ func extractZip(zipPath, destPath string) error {
 			return fmt.Errorf("failed to open file in zip: %w", err)
 		}
 
-		destFile, err := os.Create(filePath)
+		destFile, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, file.Mode().Perm())
 		if err != nil {
 			srcFile.Close()
 			return fmt.Errorf("failed to create destination file: %w", err)
 		}
 
-		_, err = io.Copy(destFile, srcFile)
+		_, err = io.CopyN(destFile, srcFile, int64(file.UncompressedSize64))
 		srcFile.Close()
 		destFile.Close()
-		if err != nil {
+		if err != nil && err != io.EOF {
 			return fmt.Errorf("failed to copy file contents: %w", err)
 		}
 	}